@@ -0,0 +1,48 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package contracts holds the data structures shared between the agent and its plugins.
+package contracts
+
+// ResultStatus represents the outcome of a plugin or document step.
+type ResultStatus string
+
+const (
+	ResultStatusSuccess          ResultStatus = "Success"
+	ResultStatusFailed           ResultStatus = "Failed"
+	ResultStatusSuccessAndReboot ResultStatus = "SuccessAndReboot"
+)
+
+// IsSuccess reports whether status is a successful outcome, with or without a pending reboot.
+func (s ResultStatus) IsSuccess() bool {
+	return s == ResultStatusSuccess || s == ResultStatusSuccessAndReboot
+}
+
+// IsReboot reports whether status requires a reboot to finish the operation.
+func (s ResultStatus) IsReboot() bool {
+	return s == ResultStatusSuccessAndReboot
+}
+
+// PluginOutputter is the surface a plugin implementation uses to accumulate diagnostic output and report
+// its terminal outcome.
+type PluginOutputter interface {
+	GetStatus() ResultStatus
+	GetExitCode() int
+	GetStderr() string
+	// AppendError records a diagnostic line (e.g. a structured failure reason) without altering status.
+	AppendError(message string)
+	MarkAsFailed(log interface{}, err error)
+	MarkAsSucceeded()
+	MarkAsSuccessWithReboot()
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package configurepackage
+
+import (
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/installer"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/localpackages"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/trace"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+)
+
+// defaultStaleStateTimeout is used when the document doesn't set StaleStateTimeoutSeconds, so stuck-state
+// recovery is on by default rather than something an operator has to opt into. It's twice a generous
+// single-phase install timeout, long enough that a genuinely slow (but still running) install won't trip it.
+const defaultStaleStateTimeout = 2 * time.Hour
+
+// ConfigurePackagePluginInput is the typed document input to the aws:configurePackage plugin.
+type ConfigurePackagePluginInput struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Source  string `json:"source"`
+	Action  string `json:"action"`
+
+	// DisableAutomaticRollback opts the invocation out of the automatic rollback a failed install/uninstall
+	// would otherwise trigger, leaving the package in a terminal Failed state for manual recovery instead.
+	DisableAutomaticRollback bool `json:"disableAutomaticRollback"`
+
+	// StaleStateTimeoutSeconds bounds how long a package may sit in a transitional install state (e.g.
+	// across a reboot or a killed agent) before it is treated as stuck rather than re-entered. Zero (the
+	// default) falls back to defaultStaleStateTimeout, so the check is on unless an operator explicitly opts
+	// out with a negative value.
+	StaleStateTimeoutSeconds int `json:"staleStateTimeoutSeconds"`
+
+	// PhaseTimeoutSeconds bounds each individual install/update/uninstall/validate call. Zero (the
+	// default) disables the timeout, preserving the prior unbounded behavior.
+	PhaseTimeoutSeconds int `json:"phaseTimeoutSeconds"`
+}
+
+// Execute runs the ConfigurePackage plugin for a single document step, translating the document's typed
+// input into the lower-level parameters executeConfigurePackage acts on. inst/uninst/repository are
+// resolved by the caller from input.Name/input.Version/the package's current install state, as they were
+// before this plugin supported rollback/recovery/cancellation.
+func Execute(
+	tracer trace.Tracer,
+	context context.T,
+	input ConfigurePackagePluginInput,
+	repository localpackages.Repository,
+	inst installer.Installer,
+	uninst installer.Installer,
+	isUpdateInPlace bool,
+	cancelFlag task.CancelFlag,
+	initialInstallState localpackages.InstallState,
+	output contracts.PluginOutputter) {
+
+	rollbackEnabled := !input.DisableAutomaticRollback
+	staleStateTimeout := defaultStaleStateTimeout
+	if input.StaleStateTimeoutSeconds != 0 {
+		staleStateTimeout = time.Duration(input.StaleStateTimeoutSeconds) * time.Second
+	}
+	if staleStateTimeout < 0 {
+		staleStateTimeout = 0
+	}
+	phaseTimeout := time.Duration(input.PhaseTimeoutSeconds) * time.Second
+
+	executeConfigurePackage(tracer, context, repository, inst, uninst, isUpdateInPlace, rollbackEnabled, staleStateTimeout, phaseTimeout, cancelFlag, initialInstallState, output)
+}
@@ -17,17 +17,65 @@ package configurepackage
 
 import (
 	"fmt"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
 	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/installer"
 	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/localpackages"
 	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/trace"
+	"github.com/aws/amazon-ssm-agent/agent/task"
 )
 
-// TODO: consider passing in the timeout and cancel channels - does cancel trigger rollback?
-// executeConfigurePackage performs install, update and uninstall actions, with rollback support and recovery after reboots
+// transitionalInstallStates are the install states that represent a package mid-operation; a package
+// still in one of these states after more than staleStateTimeout has elapsed is considered stuck.
+var transitionalInstallStates = map[localpackages.InstallState]bool{
+	localpackages.Installing:        true,
+	localpackages.Updating:          true,
+	localpackages.Uninstalling:      true,
+	localpackages.Upgrading:         true,
+	localpackages.RollbackInstall:   true,
+	localpackages.RollbackUninstall: true,
+}
+
+// stuckInstaller picks the installer whose version is the one actually in the transitional state, so
+// recovery acts on the half-finished version rather than the one being requested. This mirrors
+// executeConfigurePackage's own dispatch switch: Uninstalling/Upgrading/RollbackInstall all call into
+// executeUninstall/executeInstall with the outer inst/uninst arguments swapped, so their stuck package is
+// uninst. RollbackUninstall dispatches executeUninstall(tracer, ctx, repo, uninst, inst, ...), whose own
+// uninst parameter - the one actually uninstalled and state-stamped - resolves to the outer inst, so that
+// one state is the exception and maps to inst instead.
+func stuckInstaller(inst installer.Installer, uninst installer.Installer, state localpackages.InstallState) installer.Installer {
+	switch state {
+	case localpackages.Uninstalling, localpackages.Upgrading, localpackages.RollbackInstall:
+		if uninst != nil {
+			return uninst
+		}
+	}
+	return inst
+}
+
+// missingUpdateScriptExitCode is the exit code the shell reports when an in-place update's script doesn't
+// exist ("command not found"), used to detect that case deterministically instead of string-matching stderr.
+const missingUpdateScriptExitCode = 127
+
+// markFailed marks the plugin as failed and attaches a structured FailureReason plus its stable
+// machine-readable code, so downstream consumers (console, automations) can react to a specific failure
+// class programmatically instead of string-matching stderr.
+func markFailed(output contracts.PluginOutputter, reason FailureReason) {
+	output.AppendError(fmt.Sprintf("FailureReason=%s", failureCode(reason)))
+	output.MarkAsFailed(nil, nil)
+}
+
+// executeConfigurePackage performs install, update and uninstall actions, with rollback support and recovery after reboots.
+// rollbackEnabled controls whether a failed install/uninstall automatically triggers the recursive rollback path; when
+// false, a failure is left as-is (Failed/RollbackInstall) for the caller to investigate or recover via a follow-on document.
+// staleStateTimeout bounds how long a package may sit in a transitional state (e.g. across a reboot or a
+// killed agent) before it is treated as stuck rather than re-entered; a zero timeout disables the check.
+// phaseTimeout bounds each individual Install/Update/Uninstall/Validate call; cancelFlag carries document-level
+// cancellation, and both are honored by interrupting the running phase and killing its process group rather
+// than leaving the package in a transitional state indefinitely.
 func executeConfigurePackage(
 	tracer trace.Tracer,
 	context context.T,
@@ -35,29 +83,181 @@ func executeConfigurePackage(
 	inst installer.Installer,
 	uninst installer.Installer,
 	isUpdateInPlace bool,
+	rollbackEnabled bool,
+	staleStateTimeout time.Duration,
+	phaseTimeout time.Duration,
+	cancelFlag task.CancelFlag,
 	initialInstallState localpackages.InstallState,
 	output contracts.PluginOutputter) {
 
 	trace := tracer.BeginSection(fmt.Sprintf("execute configure - state: %v", initialInstallState))
 	defer trace.End()
 
-	switch initialInstallState {
+	installState := initialInstallState
+	if transitionalInstallStates[installState] && staleStateTimeout > 0 {
+		stuck := stuckInstaller(inst, uninst, installState)
+		age := repository.GetInstallStateAge(tracer, stuck.PackageName())
+		if age > staleStateTimeout {
+			trace.AppendErrorf("package %v/%v has been stuck in state %v for %v, exceeding the %v stale state timeout; treating as StuckPending", stuck.PackageName(), stuck.Version(), installState, age, staleStateTimeout)
+			setNewInstallState(tracer, repository, stuck, localpackages.Failed)
+			if !rollbackEnabled {
+				markFailed(output, StuckPending)
+				return
+			}
+			recoverStuckPackage(tracer, context, repository, stuck, phaseTimeout, cancelFlag)
+			installState = localpackages.None
+		}
+	}
+
+	switch installState {
 	case localpackages.Installing, localpackages.Updating:
 		// This could be picking up an install after reboot or an update that rebooted during install (after a successful uninstall), or a true update
-		executeInstall(tracer, context, repository, inst, uninst, isUpdateInPlace, false, output)
+		executeInstall(tracer, context, repository, inst, uninst, isUpdateInPlace, rollbackEnabled, phaseTimeout, cancelFlag, false, output)
 	case localpackages.RollbackInstall:
-		executeInstall(tracer, context, repository, uninst, inst, isUpdateInPlace, true, output)
+		executeInstall(tracer, context, repository, uninst, inst, isUpdateInPlace, rollbackEnabled, phaseTimeout, cancelFlag, true, output)
 	case localpackages.RollbackUninstall:
-		executeUninstall(tracer, context, repository, uninst, inst, isUpdateInPlace, true, output)
+		executeUninstall(tracer, context, repository, uninst, inst, isUpdateInPlace, rollbackEnabled, phaseTimeout, cancelFlag, true, output)
 	default:
 		if uninst != nil && !isUpdateInPlace {
-			executeUninstall(tracer, context, repository, inst, uninst, isUpdateInPlace, false, output)
+			executeUninstall(tracer, context, repository, inst, uninst, isUpdateInPlace, rollbackEnabled, phaseTimeout, cancelFlag, false, output)
 		} else {
-			executeInstall(tracer, context, repository, inst, uninst, isUpdateInPlace, false, output)
+			executeInstall(tracer, context, repository, inst, uninst, isUpdateInPlace, rollbackEnabled, phaseTimeout, cancelFlag, false, output)
 		}
 	}
 }
 
+// recoverStuckPackage attempts an idempotent uninstall of a package version left behind in a transitional
+// state, so that the subsequent install/uninstall attempt starts from a clean slate instead of re-running
+// the same half-finished operation.
+func recoverStuckPackage(tracer trace.Tracer, context context.T, repository localpackages.Repository, stuck installer.Installer, phaseTimeout time.Duration, cancelFlag task.CancelFlag) {
+	trace := tracer.BeginSection(fmt.Sprintf("recover stuck package %s/%s", stuck.PackageName(), stuck.Version()))
+	defer trace.End()
+
+	result, cancelled := runPhase(trace, cancelFlag, phaseTimeout, func(pc task.CancelFlag) contracts.PluginOutputter {
+		return uninstallWithCancelFlag(stuck, tracer, context, pc)
+	})
+	if cancelled {
+		trace.AppendErrorf("recovery uninstall of stuck version %s was cancelled or timed out; manual intervention may be required", stuck.Version())
+		return
+	}
+	trace.WithExitcode(int64(result.GetExitCode()))
+	if !result.GetStatus().IsSuccess() {
+		trace.AppendErrorf("recovery uninstall of stuck version %s failed; manual intervention may be required", stuck.Version())
+		return
+	}
+	cleanupAfterUninstall(tracer, repository, stuck, nil)
+}
+
+// phaseCancelFlag is the task.CancelFlag actually handed to the installer for a single phase call. It
+// relays the caller's own cancelFlag (document cancellation) and additionally fires on phaseTimeout, so the
+// installer always has one flag to select on that reflects both, regardless of whether the caller passed a
+// cancelFlag at all. Unlike a polling loop, Wait blocks until one of those fires, so an installer that
+// selects on it (e.g. around its child process) unblocks and can kill that process immediately instead of
+// finishing on its own.
+type phaseCancelFlag struct {
+	state  task.State
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPhaseCancelFlag() *phaseCancelFlag {
+	return &phaseCancelFlag{closed: make(chan struct{})}
+}
+
+func (f *phaseCancelFlag) Set(state task.State) {
+	f.once.Do(func() {
+		f.state = state
+		close(f.closed)
+	})
+}
+
+func (f *phaseCancelFlag) Canceled() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *phaseCancelFlag) Wait() task.State {
+	<-f.closed
+	return f.state
+}
+
+// runPhase runs a single installer phase (install/update/uninstall/validate) to completion while honoring
+// cancelFlag and phaseTimeout. The phase is always given a phaseCancelFlag to watch; runPhase relays the
+// caller's cancelFlag into it and fires it itself on timeout, so on cancellation or timeout the installer
+// is actually signaled to tear down its running script rather than left to finish unobserved. runPhase then
+// waits for the phase goroutine to return (so no goroutine is leaked) and reports cancelled=true.
+func runPhase(section trace.Trace, cancelFlag task.CancelFlag, phaseTimeout time.Duration, phase func(task.CancelFlag) contracts.PluginOutputter) (result contracts.PluginOutputter, cancelled bool) {
+	local := newPhaseCancelFlag()
+
+	done := make(chan contracts.PluginOutputter, 1)
+	go func() {
+		done <- phase(local)
+	}()
+
+	if cancelFlag != nil {
+		go func() {
+			local.Set(cancelFlag.Wait())
+		}()
+	}
+
+	var deadline <-chan time.Time
+	if phaseTimeout > 0 {
+		timer := time.NewTimer(phaseTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	select {
+	case result = <-done:
+		return result, false
+	case <-deadline:
+		section.AppendErrorf("phase timed out after %v; signalling the installer to terminate its running script", phaseTimeout)
+		local.Set(task.TimedOut)
+		<-done
+		return nil, true
+	case <-local.closed:
+		section.AppendErrorf("cancellation requested; signalling the installer to terminate its running script")
+		<-done
+		return nil, true
+	}
+}
+
+// installWithCancelFlag, updateWithCancelFlag, uninstallWithCancelFlag and validateWithCancelFlag call the
+// given installer's corresponding phase, routing through installer.CancellableInstaller when inst
+// implements it so cancelFlag actually reaches and can interrupt the running script; installers that only
+// implement installer.Installer fall back to the plain, uninterruptible call.
+func installWithCancelFlag(inst installer.Installer, tracer trace.Tracer, context context.T, cancelFlag task.CancelFlag) contracts.PluginOutputter {
+	if ci, ok := inst.(installer.CancellableInstaller); ok {
+		return ci.InstallWithCancelFlag(tracer, context, cancelFlag)
+	}
+	return inst.Install(tracer, context)
+}
+
+func updateWithCancelFlag(inst installer.Installer, tracer trace.Tracer, context context.T, cancelFlag task.CancelFlag) contracts.PluginOutputter {
+	if ci, ok := inst.(installer.CancellableInstaller); ok {
+		return ci.UpdateWithCancelFlag(tracer, context, cancelFlag)
+	}
+	return inst.Update(tracer, context)
+}
+
+func uninstallWithCancelFlag(inst installer.Installer, tracer trace.Tracer, context context.T, cancelFlag task.CancelFlag) contracts.PluginOutputter {
+	if ci, ok := inst.(installer.CancellableInstaller); ok {
+		return ci.UninstallWithCancelFlag(tracer, context, cancelFlag)
+	}
+	return inst.Uninstall(tracer, context)
+}
+
+func validateWithCancelFlag(inst installer.Installer, tracer trace.Tracer, context context.T, cancelFlag task.CancelFlag) contracts.PluginOutputter {
+	if ci, ok := inst.(installer.CancellableInstaller); ok {
+		return ci.ValidateWithCancelFlag(tracer, context, cancelFlag)
+	}
+	return inst.Validate(tracer, context)
+}
+
 // set package install state and log any error
 func setNewInstallState(tracer trace.Tracer, repository localpackages.Repository, inst installer.Installer, newInstallState localpackages.InstallState) {
 	trace := tracer.BeginSection(fmt.Sprintf("set install state install %s/%s - state: %v", inst.PackageName(), inst.Version(), newInstallState))
@@ -69,7 +269,11 @@ func setNewInstallState(tracer trace.Tracer, repository localpackages.Repository
 	trace.End()
 }
 
-// executeInstall performs install, in-place and legacy update, and validation of a package
+// executeInstall performs install, in-place and legacy update, and validation of a package.
+// When rollbackEnabled is false, a failed install is not automatically rolled back; it is left in the
+// Failed state with a clear reason so the caller can inspect it or recover via a follow-on document.
+// phaseTimeout and cancelFlag bound and interrupt each Install/Update/Validate call; a cancelled or
+// timed-out phase is recorded as a terminal Failed state rather than left transitional.
 func executeInstall(
 	tracer trace.Tracer,
 	context context.T,
@@ -77,6 +281,9 @@ func executeInstall(
 	inst installer.Installer,
 	uninst installer.Installer,
 	isUpdateInPlace bool,
+	rollbackEnabled bool,
+	phaseTimeout time.Duration,
+	cancelFlag task.CancelFlag,
 	isRollback bool,
 	output contracts.PluginOutputter) {
 
@@ -84,23 +291,47 @@ func executeInstall(
 	defer installtrace.End()
 
 	var result contracts.PluginOutputter
+	var cancelled bool
 
 	if isRollback {
 		setNewInstallState(tracer, repository, inst, localpackages.RollbackInstall)
-		result = inst.Install(tracer, context)
+		result, cancelled = runPhase(installtrace, cancelFlag, phaseTimeout, func(pc task.CancelFlag) contracts.PluginOutputter {
+			return installWithCancelFlag(inst, tracer, context, pc)
+		})
 	} else if isUpdateInPlace {
 		setNewInstallState(tracer, repository, inst, localpackages.Updating)
-		result = inst.Update(tracer, context)
+		result, cancelled = runPhase(installtrace, cancelFlag, phaseTimeout, func(pc task.CancelFlag) contracts.PluginOutputter {
+			return updateWithCancelFlag(inst, tracer, context, pc)
+		})
 	} else {
 		setNewInstallState(tracer, repository, inst, localpackages.Installing)
-		result = inst.Install(tracer, context)
+		result, cancelled = runPhase(installtrace, cancelFlag, phaseTimeout, func(pc task.CancelFlag) contracts.PluginOutputter {
+			return installWithCancelFlag(inst, tracer, context, pc)
+		})
+	}
+
+	if cancelled {
+		installtrace.AppendErrorf("Install of %v %v was cancelled or timed out", inst.PackageName(), inst.Version())
+		setNewInstallState(tracer, repository, inst, localpackages.Failed)
+		markFailed(output, Cancelled)
+		return
 	}
 
 	installtrace.WithExitcode(int64(result.GetExitCode()))
 
+	validated := false
 	if result.GetStatus() == contracts.ResultStatusSuccess {
+		validated = true
 		validatetrace := tracer.BeginSection(fmt.Sprintf("validate %s/%s - rollback: %t", inst.PackageName(), inst.Version(), isRollback))
-		result = inst.Validate(tracer, context)
+		result, cancelled = runPhase(validatetrace, cancelFlag, phaseTimeout, func(pc task.CancelFlag) contracts.PluginOutputter {
+			return validateWithCancelFlag(inst, tracer, context, pc)
+		})
+		if cancelled {
+			validatetrace.AppendErrorf("Validate of %v %v was cancelled or timed out", inst.PackageName(), inst.Version())
+			setNewInstallState(tracer, repository, inst, localpackages.Failed)
+			markFailed(output, Cancelled)
+			return
+		}
 		validatetrace.WithExitcode(int64(result.GetExitCode()))
 	}
 	if result.GetStatus().IsReboot() {
@@ -109,26 +340,36 @@ func executeInstall(
 		return
 	}
 	if !result.GetStatus().IsSuccess() {
-		// If the execution fails because update script is not present for in-place update, do not roll back.
-		// It's not ideal to rely on the error message, but it's uneasy to separate this "validation" error from actual execution error.
-		// Ideally when we have a standard that can differentiate error types based on status code or a new status (eg ValidationError),
-		// we will refactor to make use of that approach.
-		if isUpdateInPlace && strings.Contains(output.GetStderr(), "missing update script") {
+		// If the execution fails because the update script is not present for an in-place update, do not roll
+		// back. Scripts run through the standard shell, which exits 127 ("command not found") when the
+		// requested script doesn't exist, so this is a deterministic signal from the phase's own result
+		// rather than a guess based on stderr content.
+		if isUpdateInPlace && result.GetExitCode() == missingUpdateScriptExitCode {
 			setNewInstallState(tracer, repository, inst, localpackages.Installed)
-			output.MarkAsFailed(nil, nil)
+			markFailed(output, MissingUpdateScript)
 			return
 		}
 
 		installtrace.AppendErrorf("Failed to install package; install status %v", result.GetStatus())
+		scriptFailureReason := InstallScriptFailed
+		if validated {
+			scriptFailureReason = ValidationFailed
+		}
 		if isRollback || uninst == nil {
 			// Rollback failed. Mark as failed.
-			output.MarkAsFailed(nil, nil)
+			markFailed(output, scriptFailureReason)
 			// TODO: Remove from repository if this isn't the last successfully installed version?  Run uninstall to clean up?
 			setNewInstallState(tracer, repository, inst, localpackages.Failed)
 			return
 		}
+		if !rollbackEnabled {
+			installtrace.AppendInfof("Rollback is disabled; leaving %v %v in a failed state for manual recovery", inst.PackageName(), inst.Version())
+			markFailed(output, scriptFailureReason)
+			setNewInstallState(tracer, repository, inst, localpackages.Failed)
+			return
+		}
 		// Execute rollback
-		executeUninstall(tracer, context, repository, uninst, inst, isUpdateInPlace, true, output)
+		executeUninstall(tracer, context, repository, uninst, inst, isUpdateInPlace, rollbackEnabled, phaseTimeout, cancelFlag, true, output)
 		return
 	}
 	if uninst != nil {
@@ -138,7 +379,7 @@ func executeInstall(
 	if isRollback {
 		installtrace.AppendInfof("Failed to install %v %v, successfully rolled back to %v %v", uninst.PackageName(), uninst.Version(), inst.PackageName(), inst.Version())
 		setNewInstallState(tracer, repository, inst, localpackages.Installed)
-		output.MarkAsFailed(nil, nil)
+		markFailed(output, InstallScriptFailed)
 		return
 	}
 	installtrace.AppendInfof("Successfully installed %v %v", inst.PackageName(), inst.Version())
@@ -147,7 +388,11 @@ func executeInstall(
 	return
 }
 
-// executeUninstall performs uninstall of a package
+// executeUninstall performs uninstall of a package. When this uninstall is itself a rollback step
+// (isRollback true) and rollbackEnabled is false, a failure to reinstall the prior version is not retried
+// further; the package is left in RollbackInstall/Failed for the caller to recover. phaseTimeout and
+// cancelFlag bound and interrupt the Uninstall call; a cancelled or timed-out uninstall is recorded as a
+// terminal Failed state rather than left transitional.
 func executeUninstall(
 	tracer trace.Tracer,
 	context context.T,
@@ -155,6 +400,9 @@ func executeUninstall(
 	inst installer.Installer,
 	uninst installer.Installer,
 	isUpdateInPlace bool,
+	rollbackEnabled bool,
+	phaseTimeout time.Duration,
+	cancelFlag task.CancelFlag,
 	isRollback bool,
 	output contracts.PluginOutputter) {
 
@@ -173,18 +421,31 @@ func executeUninstall(
 		}
 	}
 
-	result := uninst.Uninstall(tracer, context)
+	result, cancelled := runPhase(installtrace, cancelFlag, phaseTimeout, func(pc task.CancelFlag) contracts.PluginOutputter {
+		return uninstallWithCancelFlag(uninst, tracer, context, pc)
+	})
+	if cancelled {
+		installtrace.AppendErrorf("Uninstall of %v %v was cancelled or timed out", uninst.PackageName(), uninst.Version())
+		setNewInstallState(tracer, repository, uninst, localpackages.Failed)
+		markFailed(output, Cancelled)
+		return
+	}
 	installtrace.WithExitcode(int64(result.GetExitCode()))
 
 	if !result.GetStatus().IsSuccess() {
 		installtrace.AppendErrorf("Failed to uninstall version %v of package; uninstall status %v", uninst.Version(), result.GetStatus())
-		if inst != nil {
+		if inst != nil && (!isRollback || rollbackEnabled) {
 			// Uninstall fails upon rollback. Directly try to reinstall previously installed version.
-			executeInstall(tracer, context, repository, inst, uninst, isUpdateInPlace, isRollback, output)
+			executeInstall(tracer, context, repository, inst, uninst, isUpdateInPlace, rollbackEnabled, phaseTimeout, cancelFlag, isRollback, output)
 			return
 		}
+		uninstallFailureReason := UninstallScriptFailed
+		if inst != nil {
+			installtrace.AppendInfof("Rollback is disabled; leaving %v %v in a failed state for manual recovery", uninst.PackageName(), uninst.Version())
+			uninstallFailureReason = RollbackFailed
+		}
 		setNewInstallState(tracer, repository, uninst, localpackages.Failed)
-		output.MarkAsFailed(nil, nil)
+		markFailed(output, uninstallFailureReason)
 		return
 	}
 	if result.GetStatus().IsReboot() {
@@ -193,9 +454,15 @@ func executeUninstall(
 		return
 	}
 	installtrace.AppendInfof("Successfully uninstalled %v %v", uninst.PackageName(), uninst.Version())
-	if inst != nil {
+	if inst != nil && (!isRollback || rollbackEnabled) {
 		// Uninstall succeeds upon rollback. Continue to reinstall previously installed version.
-		executeInstall(tracer, context, repository, inst, uninst, isUpdateInPlace, isRollback, output)
+		executeInstall(tracer, context, repository, inst, uninst, isUpdateInPlace, rollbackEnabled, phaseTimeout, cancelFlag, isRollback, output)
+		return
+	}
+	if inst != nil {
+		installtrace.AppendInfof("Rollback is disabled; leaving %v %v uninstalled without reinstalling %v %v", uninst.PackageName(), uninst.Version(), inst.PackageName(), inst.Version())
+		setNewInstallState(tracer, repository, uninst, localpackages.Failed)
+		markFailed(output, RollbackFailed)
 		return
 	}
 	cleanupAfterUninstall(tracer, repository, uninst, output)
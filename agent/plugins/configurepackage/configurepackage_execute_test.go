@@ -0,0 +1,393 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/installer"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/localpackages"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/trace"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeInstaller is a minimal installer.CancellableInstaller stub whose phase results are set by the test.
+// When block is non-nil, Install blocks on it instead of returning immediately, to exercise
+// cancellation/timeout. Its plain installer.Installer methods (required by the interface it embeds) just
+// delegate to the cancel-aware ones with a nil cancelFlag, mirroring how a real installer that doesn't care
+// about cancellation could implement both.
+type fakeInstaller struct {
+	name    string
+	version string
+
+	installResult   contracts.PluginOutputter
+	updateResult    contracts.PluginOutputter
+	uninstallResult contracts.PluginOutputter
+	validateResult  contracts.PluginOutputter
+
+	block chan struct{}
+}
+
+func (f *fakeInstaller) PackageName() string { return f.name }
+func (f *fakeInstaller) Version() string     { return f.version }
+
+// awaitBlockOrCancel mimics a script-running installer that selects on its subprocess finishing (block)
+// and on cancelFlag.Wait(), killing the subprocess and returning as soon as cancelFlag fires.
+func (f *fakeInstaller) awaitBlockOrCancel(cancelFlag task.CancelFlag) {
+	if f.block == nil {
+		return
+	}
+	var canceled <-chan task.State
+	if cancelFlag != nil {
+		ch := make(chan task.State, 1)
+		go func() { ch <- cancelFlag.Wait() }()
+		canceled = ch
+	}
+	select {
+	case <-f.block:
+	case <-canceled:
+	}
+}
+
+func (f *fakeInstaller) Install(tracer trace.Tracer, context context.T) contracts.PluginOutputter {
+	return f.InstallWithCancelFlag(tracer, context, nil)
+}
+func (f *fakeInstaller) Update(tracer trace.Tracer, context context.T) contracts.PluginOutputter {
+	return f.UpdateWithCancelFlag(tracer, context, nil)
+}
+func (f *fakeInstaller) Uninstall(tracer trace.Tracer, context context.T) contracts.PluginOutputter {
+	return f.UninstallWithCancelFlag(tracer, context, nil)
+}
+func (f *fakeInstaller) Validate(tracer trace.Tracer, context context.T) contracts.PluginOutputter {
+	return f.ValidateWithCancelFlag(tracer, context, nil)
+}
+
+func (f *fakeInstaller) InstallWithCancelFlag(tracer trace.Tracer, context context.T, cancelFlag task.CancelFlag) contracts.PluginOutputter {
+	f.awaitBlockOrCancel(cancelFlag)
+	return f.installResult
+}
+func (f *fakeInstaller) UpdateWithCancelFlag(tracer trace.Tracer, context context.T, cancelFlag task.CancelFlag) contracts.PluginOutputter {
+	return f.updateResult
+}
+func (f *fakeInstaller) UninstallWithCancelFlag(tracer trace.Tracer, context context.T, cancelFlag task.CancelFlag) contracts.PluginOutputter {
+	f.awaitBlockOrCancel(cancelFlag)
+	return f.uninstallResult
+}
+func (f *fakeInstaller) ValidateWithCancelFlag(tracer trace.Tracer, context context.T, cancelFlag task.CancelFlag) contracts.PluginOutputter {
+	if f.validateResult != nil {
+		return f.validateResult
+	}
+	return f.installResult
+}
+
+// fakeLegacyInstaller implements only the plain installer.Installer interface, representing a real-world
+// installer (rpm/msi/deb/docker, etc.) that predates CancellableInstaller and was never updated to
+// implement it. It proves runPhase's type-assertion falls back to the plain, uninterruptible methods
+// instead of failing to compile or panicking.
+type fakeLegacyInstaller struct {
+	name    string
+	version string
+
+	installResult   contracts.PluginOutputter
+	uninstallResult contracts.PluginOutputter
+	validateResult  contracts.PluginOutputter
+}
+
+func (f *fakeLegacyInstaller) PackageName() string { return f.name }
+func (f *fakeLegacyInstaller) Version() string     { return f.version }
+func (f *fakeLegacyInstaller) Install(tracer trace.Tracer, context context.T) contracts.PluginOutputter {
+	return f.installResult
+}
+func (f *fakeLegacyInstaller) Update(tracer trace.Tracer, context context.T) contracts.PluginOutputter {
+	return f.installResult
+}
+func (f *fakeLegacyInstaller) Uninstall(tracer trace.Tracer, context context.T) contracts.PluginOutputter {
+	return f.uninstallResult
+}
+func (f *fakeLegacyInstaller) Validate(tracer trace.Tracer, context context.T) contracts.PluginOutputter {
+	if f.validateResult != nil {
+		return f.validateResult
+	}
+	return f.installResult
+}
+
+var _ installer.Installer = (*fakeLegacyInstaller)(nil)
+var _ installer.CancellableInstaller = (*fakeInstaller)(nil)
+
+// fakeCancelFlag is a minimal task.CancelFlag stub that becomes canceled once Set (or cancel) is called.
+type fakeCancelFlag struct {
+	closed chan struct{}
+	state  task.State
+	once   sync.Once
+}
+
+func newFakeCancelFlag() *fakeCancelFlag {
+	return &fakeCancelFlag{closed: make(chan struct{})}
+}
+
+// cancel simulates the document processor requesting cancellation.
+func (f *fakeCancelFlag) cancel() { f.Set(task.ShutDown) }
+
+func (f *fakeCancelFlag) Canceled() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *fakeCancelFlag) Set(state task.State) {
+	f.once.Do(func() {
+		f.state = state
+		close(f.closed)
+	})
+}
+
+func (f *fakeCancelFlag) Wait() task.State {
+	<-f.closed
+	return f.state
+}
+
+// fakeRepository records the sequence of install states set by executeConfigurePackage.
+type fakeRepository struct {
+	localpackages.Repository
+	states   []localpackages.InstallState
+	stateAge time.Duration
+}
+
+func (r *fakeRepository) SetInstallState(tracer trace.Tracer, packageName string, version string, state localpackages.InstallState) error {
+	r.states = append(r.states, state)
+	return nil
+}
+
+func (r *fakeRepository) RemovePackage(tracer trace.Tracer, packageName string, version string) error {
+	return nil
+}
+
+func (r *fakeRepository) GetInstallStateAge(tracer trace.Tracer, packageName string) time.Duration {
+	return r.stateAge
+}
+
+// fakeOutput records the terminal outcome reported by the plugin.
+type fakeOutput struct {
+	contracts.PluginOutputter
+	status   contracts.ResultStatus
+	exitCode int
+	failed   bool
+	succeed  bool
+	reboot   bool
+	errors   []string
+}
+
+func (o *fakeOutput) GetStatus() contracts.ResultStatus { return o.status }
+func (o *fakeOutput) GetExitCode() int                  { return o.exitCode }
+func (o *fakeOutput) GetStderr() string                 { return "" }
+func (o *fakeOutput) AppendError(message string)        { o.errors = append(o.errors, message) }
+func (o *fakeOutput) MarkAsFailed(log interface{}, err error) {
+	o.failed = true
+}
+func (o *fakeOutput) MarkAsSucceeded()         { o.succeed = true }
+func (o *fakeOutput) MarkAsSuccessWithReboot() { o.reboot = true }
+
+func newFailedResult() *fakeOutput {
+	return &fakeOutput{status: contracts.ResultStatusFailed}
+}
+
+func newSucceededResult() *fakeOutput {
+	return &fakeOutput{status: contracts.ResultStatusSuccess}
+}
+
+func TestStuckInstaller(t *testing.T) {
+	inst := &fakeInstaller{name: "pkg", version: "2.0"}
+	uninst := &fakeInstaller{name: "pkg", version: "1.0"}
+
+	cases := []struct {
+		state    localpackages.InstallState
+		expected *fakeInstaller
+	}{
+		{localpackages.Installing, inst},
+		{localpackages.Updating, inst},
+		{localpackages.Uninstalling, uninst},
+		{localpackages.Upgrading, uninst},
+		{localpackages.RollbackInstall, uninst},
+		// RollbackUninstall dispatches executeUninstall(tracer, ctx, repo, uninst, inst, ...), whose own
+		// uninst parameter (the one actually uninstalled) resolves to the outer inst.
+		{localpackages.RollbackUninstall, inst},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, stuckInstaller(inst, uninst, c.state), "state %v", c.state)
+	}
+}
+
+func TestExecuteInstall_FailureRollsBackWhenEnabled(t *testing.T) {
+	inst := &fakeInstaller{name: "pkg", version: "2.0", installResult: newFailedResult(), uninstallResult: newSucceededResult()}
+	uninst := &fakeInstaller{name: "pkg", version: "1.0", uninstallResult: newSucceededResult(), installResult: newSucceededResult(), validateResult: newSucceededResult()}
+	repo := &fakeRepository{}
+	output := newFailedResult()
+
+	executeInstall(trace.NewTracer(nil), context.NewMockDefault(), repo, inst, uninst, false, true, 0, nil, false, output)
+
+	assert.Contains(t, repo.states, localpackages.RollbackUninstall)
+	assert.Contains(t, repo.states, localpackages.RollbackInstall)
+	assert.Equal(t, localpackages.Installed, repo.states[len(repo.states)-1])
+	assert.True(t, output.failed)
+}
+
+func TestExecuteInstall_FailureLeftAsFailedWhenRollbackDisabled(t *testing.T) {
+	inst := &fakeInstaller{name: "pkg", version: "2.0", installResult: newFailedResult()}
+	uninst := &fakeInstaller{name: "pkg", version: "1.0"}
+	repo := &fakeRepository{}
+	output := newFailedResult()
+
+	executeInstall(trace.NewTracer(nil), context.NewMockDefault(), repo, inst, uninst, false, false, 0, nil, false, output)
+
+	assert.NotContains(t, repo.states, localpackages.RollbackUninstall)
+	assert.Contains(t, repo.states, localpackages.Failed)
+	assert.True(t, output.failed)
+}
+
+func TestExecuteUninstall_ReinstallSkippedWhenRollbackDisabled(t *testing.T) {
+	inst := &fakeInstaller{name: "pkg", version: "1.0"}
+	uninst := &fakeInstaller{name: "pkg", version: "2.0", uninstallResult: newFailedResult()}
+	repo := &fakeRepository{}
+	output := newFailedResult()
+
+	executeUninstall(trace.NewTracer(nil), context.NewMockDefault(), repo, inst, uninst, false, false, 0, nil, true, output)
+
+	assert.Contains(t, repo.states, localpackages.Failed)
+	assert.True(t, output.failed)
+}
+
+func TestExecuteConfigurePackage_StuckStateRecoversAndProceedsWhenRollbackEnabled(t *testing.T) {
+	inst := &fakeInstaller{name: "pkg", version: "2.0", installResult: newSucceededResult(), validateResult: newSucceededResult(), uninstallResult: newSucceededResult()}
+	repo := &fakeRepository{stateAge: time.Hour}
+	output := newFailedResult()
+
+	executeConfigurePackage(trace.NewTracer(nil), context.NewMockDefault(), repo, inst, nil, false, true, time.Minute, 0, nil, localpackages.Installing, output)
+
+	assert.Contains(t, repo.states, localpackages.Failed)
+	assert.Contains(t, repo.states, localpackages.Installed)
+	assert.True(t, output.succeed)
+}
+
+func TestExecuteConfigurePackage_StuckStateSurfacedWhenRollbackDisabled(t *testing.T) {
+	inst := &fakeInstaller{name: "pkg", version: "2.0"}
+	repo := &fakeRepository{stateAge: time.Hour}
+	output := newFailedResult()
+
+	executeConfigurePackage(trace.NewTracer(nil), context.NewMockDefault(), repo, inst, nil, false, false, time.Minute, 0, nil, localpackages.Installing, output)
+
+	assert.Equal(t, []localpackages.InstallState{localpackages.Failed}, repo.states)
+	assert.True(t, output.failed)
+}
+
+func TestExecuteInstall_CancellationUnwindsWithoutLeakingGoroutine(t *testing.T) {
+	block := make(chan struct{})
+	inst := &fakeInstaller{name: "pkg", version: "2.0", block: block, installResult: newFailedResult()}
+	repo := &fakeRepository{}
+	output := newFailedResult()
+	cancelFlag := newFakeCancelFlag()
+
+	done := make(chan struct{})
+	go func() {
+		executeInstall(trace.NewTracer(nil), context.NewMockDefault(), repo, inst, nil, false, false, 0, cancelFlag, false, output)
+		close(done)
+	}()
+
+	cancelFlag.cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("executeInstall did not unwind after cancellation")
+	}
+
+	assert.Contains(t, repo.states, localpackages.Failed)
+	assert.True(t, output.failed)
+}
+
+func TestExecuteInstall_TimeoutUnwindsWithoutLeakingGoroutine(t *testing.T) {
+	block := make(chan struct{})
+	inst := &fakeInstaller{name: "pkg", version: "2.0", block: block, installResult: newFailedResult()}
+	repo := &fakeRepository{}
+	output := newFailedResult()
+
+	done := make(chan struct{})
+	go func() {
+		executeInstall(trace.NewTracer(nil), context.NewMockDefault(), repo, inst, nil, false, false, time.Millisecond, nil, false, output)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("executeInstall did not unwind after timeout")
+	}
+
+	assert.Contains(t, repo.states, localpackages.Failed)
+	assert.True(t, output.failed)
+}
+
+func TestExecuteInstall_FailureAttachesInstallScriptFailedReason(t *testing.T) {
+	inst := &fakeInstaller{name: "pkg", version: "2.0", installResult: newFailedResult()}
+	repo := &fakeRepository{}
+	output := newFailedResult()
+
+	executeInstall(trace.NewTracer(nil), context.NewMockDefault(), repo, inst, nil, false, false, 0, nil, false, output)
+
+	assert.Contains(t, output.errors, fmt.Sprintf("FailureReason=%s", InstallScriptFailed))
+}
+
+func TestExecuteInstall_MissingUpdateScriptLeftAsInstalledWithoutRollback(t *testing.T) {
+	inst := &fakeInstaller{name: "pkg", version: "2.0", updateResult: &fakeOutput{status: contracts.ResultStatusFailed, exitCode: missingUpdateScriptExitCode}}
+	uninst := &fakeInstaller{name: "pkg", version: "1.0"}
+	repo := &fakeRepository{}
+	output := newFailedResult()
+
+	executeInstall(trace.NewTracer(nil), context.NewMockDefault(), repo, inst, uninst, true, true, 0, nil, false, output)
+
+	assert.NotContains(t, repo.states, localpackages.RollbackUninstall)
+	assert.Equal(t, localpackages.Installed, repo.states[len(repo.states)-1])
+	assert.Contains(t, output.errors, fmt.Sprintf("FailureReason=%s", MissingUpdateScript))
+}
+
+func TestExecuteInstall_SucceedsWithLegacyNonCancellableInstaller(t *testing.T) {
+	inst := &fakeLegacyInstaller{name: "pkg", version: "2.0", installResult: newSucceededResult(), validateResult: newSucceededResult()}
+	repo := &fakeRepository{}
+	output := newFailedResult()
+
+	executeInstall(trace.NewTracer(nil), context.NewMockDefault(), repo, inst, nil, false, false, 0, nil, false, output)
+
+	assert.Contains(t, repo.states, localpackages.Installed)
+	assert.True(t, output.succeed)
+}
+
+func TestExecuteUninstall_FailureAttachesUninstallScriptFailedReason(t *testing.T) {
+	uninst := &fakeInstaller{name: "pkg", version: "1.0", uninstallResult: newFailedResult()}
+	repo := &fakeRepository{}
+	output := newFailedResult()
+
+	executeUninstall(trace.NewTracer(nil), context.NewMockDefault(), repo, nil, uninst, false, false, 0, nil, false, output)
+
+	assert.Contains(t, output.errors, fmt.Sprintf("FailureReason=%s", UninstallScriptFailed))
+}
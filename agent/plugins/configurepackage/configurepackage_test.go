@@ -0,0 +1,53 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/localpackages"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/trace"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecute_StaleStateCheckDefaultsOnWhenUnset(t *testing.T) {
+	inst := &fakeInstaller{name: "pkg", version: "2.0"}
+	repo := &fakeRepository{stateAge: defaultStaleStateTimeout + time.Hour}
+	output := newFailedResult()
+	// DisableAutomaticRollback keeps this test focused on whether the stale-state check fires at all by
+	// default; it surfaces StuckPending directly instead of exercising the full recovery/reinstall path,
+	// which belongs to TestExecuteConfigurePackage_StuckStateRecoversAndProceedsWhenRollbackEnabled.
+	input := ConfigurePackagePluginInput{Name: "pkg", Version: "2.0", DisableAutomaticRollback: true}
+
+	Execute(trace.NewTracer(nil), context.NewMockDefault(), input, repo, inst, nil, false, nil, localpackages.Installing, output)
+
+	assert.Contains(t, repo.states, localpackages.Failed)
+	assert.True(t, output.failed)
+}
+
+func TestExecute_StaleStateCheckDisabledByNegativeTimeout(t *testing.T) {
+	inst := &fakeInstaller{name: "pkg", version: "2.0", installResult: newSucceededResult(), validateResult: newSucceededResult()}
+	repo := &fakeRepository{stateAge: defaultStaleStateTimeout + time.Hour}
+	output := newFailedResult()
+	input := ConfigurePackagePluginInput{Name: "pkg", Version: "2.0", StaleStateTimeoutSeconds: -1}
+
+	Execute(trace.NewTracer(nil), context.NewMockDefault(), input, repo, inst, nil, false, nil, localpackages.Installing, output)
+
+	assert.NotContains(t, repo.states, localpackages.Failed)
+	assert.True(t, output.succeed)
+}
@@ -0,0 +1,49 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+// FailureReason is a closed set of machine-readable reasons a ConfigurePackage invocation can fail,
+// so callers can react programmatically to a specific failure class instead of scraping stderr.
+type FailureReason string
+
+const (
+	// ResolutionFailed indicates the requested package/version could not be resolved.
+	ResolutionFailed FailureReason = "ResolutionFailed"
+	// DownloadFailed indicates the package artifact could not be downloaded.
+	DownloadFailed FailureReason = "DownloadFailed"
+	// InstallScriptFailed indicates the package's install (or update) script exited with a failure status.
+	InstallScriptFailed FailureReason = "InstallScriptFailed"
+	// ValidationFailed indicates the package's validate script exited with a failure status.
+	ValidationFailed FailureReason = "ValidationFailed"
+	// UninstallScriptFailed indicates the package's uninstall script exited with a failure status.
+	UninstallScriptFailed FailureReason = "UninstallScriptFailed"
+	// MissingUpdateScript indicates an in-place update was requested but the package has no update script.
+	MissingUpdateScript FailureReason = "MissingUpdateScript"
+	// RollbackFailed indicates the rollback install/uninstall triggered by a failure itself failed.
+	RollbackFailed FailureReason = "RollbackFailed"
+	// Cancelled indicates the operation was interrupted by document cancellation or a phase timeout.
+	Cancelled FailureReason = "Cancelled"
+	// StuckPending indicates the package was found in a transitional state for longer than the stale
+	// state timeout and was recovered (or surfaced) rather than re-entered.
+	StuckPending FailureReason = "StuckPending"
+)
+
+// failureCode returns the stable machine-readable code attached to plugin output for a given reason.
+// Today the code is just the reason's string value, but it is kept distinct from FailureReason so the
+// wire format can evolve independently of the Go type.
+func failureCode(reason FailureReason) string {
+	return string(reason)
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package installer defines the interface ConfigurePackage uses to drive a single package version
+// through its install/update/uninstall/validate scripts.
+package installer
+
+import (
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/trace"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+)
+
+// Installer runs a single package version's lifecycle scripts.
+type Installer interface {
+	PackageName() string
+	Version() string
+	Install(tracer trace.Tracer, context context.T) contracts.PluginOutputter
+	Update(tracer trace.Tracer, context context.T) contracts.PluginOutputter
+	Uninstall(tracer trace.Tracer, context context.T) contracts.PluginOutputter
+	Validate(tracer trace.Tracer, context context.T) contracts.PluginOutputter
+}
+
+// CancellableInstaller is implemented by an Installer that can react to a task.CancelFlag partway through
+// a phase - e.g. by killing its running child process - instead of only returning once the phase finishes
+// on its own. It is a separate, additive interface rather than new parameters on Installer's own methods,
+// so existing Installer implementations (rpm/msi/deb/docker installers, etc.) keep compiling unmodified;
+// runPhase type-asserts for CancellableInstaller and falls back to the plain Installer methods - without
+// real interruption, but otherwise unchanged behavior - when an installer doesn't implement it.
+type CancellableInstaller interface {
+	Installer
+	InstallWithCancelFlag(tracer trace.Tracer, context context.T, cancelFlag task.CancelFlag) contracts.PluginOutputter
+	UpdateWithCancelFlag(tracer trace.Tracer, context context.T, cancelFlag task.CancelFlag) contracts.PluginOutputter
+	UninstallWithCancelFlag(tracer trace.Tracer, context context.T, cancelFlag task.CancelFlag) contracts.PluginOutputter
+	ValidateWithCancelFlag(tracer trace.Tracer, context context.T, cancelFlag task.CancelFlag) contracts.PluginOutputter
+}
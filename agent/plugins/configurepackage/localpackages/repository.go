@@ -0,0 +1,120 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package localpackages manages the packages ConfigurePackage has installed locally, including the
+// install state each package version is currently in.
+package localpackages
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/trace"
+)
+
+// InstallState represents where a package version is in its install/uninstall/rollback lifecycle.
+type InstallState int
+
+const (
+	None InstallState = iota
+	Installing
+	Installed
+	Updating
+	RollbackInstall
+	RollbackUninstall
+	Uninstalling
+	Upgrading
+	Failed
+)
+
+// Repository is the local store of installed package versions and their install state.
+type Repository interface {
+	// SetInstallState records the new install state for packageName/version and stamps the time of the
+	// change, so a later GetInstallStateAge call can tell how long the package has been sitting in it.
+	SetInstallState(tracer trace.Tracer, packageName string, version string, state InstallState) error
+	// RemovePackage removes packageName/version from the repository.
+	RemovePackage(tracer trace.Tracer, packageName string, version string) error
+	// GetInstallStateAge returns how long packageName has been sitting in its current install state,
+	// measured since the last call to SetInstallState for that package. It returns zero if the package's
+	// state has never been recorded.
+	GetInstallStateAge(tracer trace.Tracer, packageName string) time.Duration
+}
+
+// installStateRecord is the on-disk record of a package's current install state, written alongside the
+// state itself so GetInstallStateAge survives a reboot, an agent crash, or a killed installer - exactly
+// the scenarios the stale-state check exists to detect.
+type installStateRecord struct {
+	State           InstallState
+	LastStateChange time.Time
+}
+
+// localRepository is the default Repository implementation backed by the on-disk package manifests.
+type localRepository struct {
+	mu   sync.Mutex
+	root string
+}
+
+// NewRepository returns the default Repository implementation, persisting its bookkeeping under root
+// (typically the agent's package install root).
+func NewRepository(root string) Repository {
+	return &localRepository{root: root}
+}
+
+func (r *localRepository) stateFilePath(packageName string) string {
+	return filepath.Join(r.root, packageName, "installstate.json")
+}
+
+func (r *localRepository) SetInstallState(tracer trace.Tracer, packageName string, version string, state InstallState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path := r.stateFilePath(packageName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(installStateRecord{State: state, LastStateChange: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, 0644)
+}
+
+func (r *localRepository) RemovePackage(tracer trace.Tracer, packageName string, version string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return os.RemoveAll(filepath.Dir(r.stateFilePath(packageName)))
+}
+
+func (r *localRepository) GetInstallStateAge(tracer trace.Tracer, packageName string) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	content, err := os.ReadFile(r.stateFilePath(packageName))
+	if err != nil {
+		return 0
+	}
+
+	var record installStateRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		return 0
+	}
+
+	return time.Since(record.LastStateChange)
+}
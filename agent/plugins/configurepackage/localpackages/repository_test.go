@@ -0,0 +1,45 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package localpackages
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/plugins/configurepackage/trace"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetInstallStateAge_SurvivesNewRepositoryInstance(t *testing.T) {
+	root := t.TempDir()
+
+	first := NewRepository(root)
+	err := first.SetInstallState(trace.NewTracer(nil), "pkg", "1.0", Installing)
+	assert.NoError(t, err)
+
+	// A fresh Repository instance (simulating the agent restarting after a reboot or crash) must still
+	// see the stamped state-change time, since it was never kept only in the prior instance's memory.
+	second := NewRepository(root)
+	age := second.GetInstallStateAge(trace.NewTracer(nil), "pkg")
+
+	assert.True(t, age >= 0)
+	assert.True(t, age < time.Minute)
+}
+
+func TestGetInstallStateAge_UnknownPackageIsZero(t *testing.T) {
+	repo := NewRepository(t.TempDir())
+
+	assert.Equal(t, time.Duration(0), repo.GetInstallStateAge(trace.NewTracer(nil), "missing"))
+}
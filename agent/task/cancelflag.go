@@ -0,0 +1,39 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package task holds the primitives used to run and cooperatively cancel long-running agent work.
+package task
+
+// State is the terminal reason a long-running operation was asked to stop.
+type State int
+
+const (
+	// Pending indicates no cancellation or timeout has been requested yet.
+	Pending State = iota
+	// ShutDown indicates the document/command was cancelled, or the agent itself is shutting down.
+	ShutDown
+	// TimedOut indicates a caller-defined deadline elapsed before the operation completed.
+	TimedOut
+)
+
+// CancelFlag lets a long-running plugin operation observe a cooperative cancellation request so it can
+// tear down whatever it's running (e.g. kill a child process) instead of blocking indefinitely.
+type CancelFlag interface {
+	// Set records that state was requested and wakes up any goroutine blocked in Wait.
+	Set(state State)
+	// Canceled reports whether Set has been called, without blocking.
+	Canceled() bool
+	// Wait blocks until Set is called and returns the state it was called with.
+	Wait() State
+}